@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+type (
+	// domainCreateRequest is the JSON body accepted by POST /domains: the
+	// full libvirtxml.Domain schema, plus an optional cloud_init block
+	// for seeding cloud-init/NoCloud user data.
+	domainCreateRequest struct {
+		libvirtxml.Domain
+		CloudInit *CloudInitConfig `json:"cloud_init,omitempty"`
+	}
+
+	// CloudInitConfig supplies the NoCloud cloud-init seed data baked
+	// into an ISO and attached to the domain as a cdrom. MetaData
+	// defaults to a minimal instance-id/local-hostname derived from the
+	// domain name when omitted. Ignition is out of scope for now: it is
+	// delivered via a kernel/firmware argument rather than a seed disk,
+	// which the current libvirtxml.Domain passthrough already covers for
+	// callers who set it directly (e.g. via os.Cmdline).
+	CloudInitConfig struct {
+		UserData      string `json:"user_data,omitempty"`
+		MetaData      string `json:"meta_data,omitempty"`
+		NetworkConfig string `json:"network_config,omitempty"`
+	}
+)
+
+// buildCloudInitISO writes the given cloud-init config out as a NoCloud
+// seed and packs it into an ISO9660 image via genisoimage, returning the
+// image's path. The containing temp directory is intentionally left in
+// place: it's the backing store for the cdrom attached to the domain.
+func buildCloudInitISO(domainName string, cfg *CloudInitConfig) (string, error) {
+	dir, err := ioutil.TempDir("", fmt.Sprintf("cloud-init-%s-", domainName))
+	if err != nil {
+		return "", err
+	}
+
+	metaData := cfg.MetaData
+	if metaData == "" {
+		metaData = fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", domainName, domainName)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "meta-data"), []byte(metaData), 0644); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "user-data"), []byte(cfg.UserData), 0644); err != nil {
+		return "", err
+	}
+
+	args := []string{"-output", "seed.iso", "-volid", "cidata", "-joliet", "-rock", "user-data", "meta-data"}
+	if cfg.NetworkConfig != "" {
+		if err := ioutil.WriteFile(filepath.Join(dir, "network-config"), []byte(cfg.NetworkConfig), 0644); err != nil {
+			return "", err
+		}
+		args = append(args, "network-config")
+	}
+
+	cmd := exec.Command("genisoimage", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("genisoimage: %s: %s", err, out)
+	}
+
+	return filepath.Join(dir, "seed.iso"), nil
+}
+
+// attachCloudInitSeed points spec at a cdrom carrying the rendered
+// cloud-init seed ISO.
+func attachCloudInitSeed(spec *libvirtxml.Domain, cfg *CloudInitConfig) error {
+	isoPath, err := buildCloudInitISO(spec.Name, cfg)
+	if err != nil {
+		return err
+	}
+
+	if spec.Devices == nil {
+		spec.Devices = &libvirtxml.DomainDeviceList{}
+	}
+	spec.Devices.Disks = append(spec.Devices.Disks, libvirtxml.DomainDisk{
+		Device:   "cdrom",
+		Driver:   &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+		Source:   &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: isoPath}},
+		Target:   &libvirtxml.DomainDiskTarget{Dev: "hdd", Bus: "ide"},
+		ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+	})
+	return nil
+}
+
+// createDomain handles POST /domains. The request body is the JSON form
+// of a libvirtxml.Domain plus an optional cloud_init block, which is
+// marshaled to XML and handed to DomainDefineXML. Pass ?start=true to
+// create (start) the domain immediately after it is defined.
+func createDomain(c *Context) error {
+	var req domainCreateRequest
+	dec := json.NewDecoder(c.Request.Body)
+	if err := dec.Decode(&req); err != nil {
+		return c.JSONError(400, err)
+	}
+
+	if req.CloudInit != nil {
+		if err := attachCloudInitSeed(&req.Domain, req.CloudInit); err != nil {
+			return c.JSONError(500, err)
+		}
+	}
+
+	xmlDoc, err := xml.Marshal(&req.Domain)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+
+	dom, err := c.V.DomainDefineXML(string(xmlDoc))
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+
+	started := false
+	if c.Query("start") == "true" {
+		if err := dom.Create(); err != nil {
+			return c.JSONError(500, err)
+		}
+		started = true
+	}
+
+	d, err := buildDomain(&dom)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+	c.FreeList(d)
+
+	if started && c.Query("wait_for_lease") != "" {
+		if attachLeaseInfo(c, d) {
+			return nil
+		}
+	}
+
+	c.JSON(201, d)
+	return nil
+}
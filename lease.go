@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alexzorin/libvirt-go"
+)
+
+const (
+	defaultLeaseTimeout = 5 * time.Minute
+	// maxLeaseTimeout caps the client-supplied ?timeout= value, so a
+	// single wait_for_lease request cannot block for an arbitrary,
+	// client-chosen duration. The pooled connection's admission slot is
+	// not held during the wait: attachLeaseInfo releases it first.
+	maxLeaseTimeout   = 30 * time.Minute
+	leasePollInterval = 1 * time.Second
+)
+
+// InterfaceAddress is the JSON shape of the addresses reported for a
+// domain interface once it has picked up a lease.
+type InterfaceAddress struct {
+	Name  string   `json:"name"`
+	Mac   string   `json:"mac"`
+	Addrs []string `json:"addrs"`
+}
+
+// attachLeaseInfo implements the `wait_for_lease`/`timeout` query
+// parameters on domain create/start. It blocks until the requested
+// interface (matched by name or MAC) has at least one address, or the
+// timeout elapses. On success it sets d.Addresses and returns false so
+// the caller proceeds to write its usual 200/201 response. On timeout it
+// writes the 504 response itself and returns true so the caller does not
+// write a second response.
+func attachLeaseInfo(c *Context, d *Domain) bool {
+	want := c.Query("wait_for_lease")
+
+	timeout := defaultLeaseTimeout
+	if raw := c.Query("timeout"); raw != "" {
+		if parsed, err := time.ParseDuration(raw); err == nil {
+			timeout = parsed
+		}
+	}
+	if timeout <= 0 || timeout > maxLeaseTimeout {
+		timeout = maxLeaseTimeout
+	}
+
+	if !interfaceCanLease(d, want) {
+		c.JSONError(400, leaseUnsupportedError(want))
+		return true
+	}
+
+	// The poll loop below can run for up to maxLeaseTimeout. Give up our
+	// pooled connection's admission slot before it starts: d's
+	// underlying libvirt handle stays valid independent of the
+	// connection pool's own bookkeeping, so there is nothing left here
+	// that needs it.
+	c.Release()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		addrs, err := pollLeaseAddresses(d.VirDomain, want)
+		if err != nil {
+			c.JSONError(500, err)
+			return true
+		}
+		if len(addrs.Addrs) > 0 {
+			d.Addresses = []InterfaceAddress{addrs}
+			return false
+		}
+
+		if time.Now().After(deadline) {
+			c.JSONError(504, leaseTimeoutError(want, timeout))
+			return true
+		}
+		time.Sleep(leasePollInterval)
+	}
+}
+
+// pollLeaseAddresses checks the DHCP lease source first, then falls back
+// to the guest agent source, and returns the first match for the
+// requested interface.
+func pollLeaseAddresses(dom *libvirt.VirDomain, want string) (InterfaceAddress, error) {
+	for _, source := range []uint{
+		libvirt.VIR_DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE,
+		libvirt.VIR_DOMAIN_INTERFACE_ADDRESSES_SRC_AGENT,
+	} {
+		ifaces, err := dom.ListAllInterfaceAddresses(source)
+		if err != nil {
+			continue
+		}
+		for _, iface := range ifaces {
+			if iface.Name != want && iface.Hwaddr != want {
+				continue
+			}
+			addr := InterfaceAddress{Name: iface.Name, Mac: iface.Hwaddr}
+			for _, a := range iface.Addrs {
+				addr.Addrs = append(addr.Addrs, a.Addr)
+			}
+			if len(addr.Addrs) > 0 {
+				return addr, nil
+			}
+		}
+	}
+	return InterfaceAddress{}, nil
+}
+
+// interfaceCanLease reports whether the requested interface exists in
+// the domain's definition and is of a type that can plausibly receive a
+// lease (i.e. not a bare bridge interface with no managed network
+// behind it, which libvirt has no way to report a lease for).
+func interfaceCanLease(d *Domain, want string) bool {
+	if d.Devices == nil {
+		return false
+	}
+	for _, iface := range d.Devices.Interfaces {
+		name := ""
+		if iface.Target != nil {
+			name = iface.Target.Dev
+		}
+		mac := ""
+		if iface.MAC != nil {
+			mac = iface.MAC.Address
+		}
+		if name != want && mac != want {
+			continue
+		}
+		if iface.Source != nil && iface.Source.Bridge != nil && iface.Source.Network == nil {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func leaseUnsupportedError(want string) error {
+	return fmt.Errorf("interface %q cannot report a lease (unknown, or a bridge interface with no managed network)", want)
+}
+
+func leaseTimeoutError(want string, timeout time.Duration) error {
+	return fmt.Errorf("timed out after %s waiting for a lease on interface %q", timeout, want)
+}
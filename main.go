@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/xml"
+	"flag"
 	"fmt"
 	"github.com/alexzorin/libvirt-go"
 	"github.com/gin-gonic/gin"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
 	"runtime"
 	"strings"
+	"sync"
 )
 
 var stateDict = map[uint8]string{
@@ -21,92 +24,15 @@ var stateDict = map[uint8]string{
 }
 
 type (
-	DiskDriver struct {
-		Name string `xml:"name,attr" json:"name"`
-		Type string `xml:"type,attr" json:"type"`
-	}
-
-	DiskSource struct {
-		File   string `xml:"file,omitempty,attr" json:"file,omitempty"`
-		Device string `xml:"dev,omitempty,attr" json:"device,omitempty"`
-	}
-
-	DiskTarget struct {
-		Dev string `xml:"dev,attr" json:"dev"`
-		Bus string `xml:"bus,attr" json:"bus"`
-	}
-
-	Disk struct {
-		Type   string     `xml:"type,attr"  json:"type"`
-		Device string     `xml:"device,attr"  json:"device"`
-		Driver DiskDriver `xml:"driver"  json:"driver"`
-		Source DiskSource `xml:"source" json:"source"`
-		Target DiskTarget `xml:"target" json:"target"`
-	}
-
-	InterfaceSource struct {
-		Network string `xml:"network,omitempty,attr" json:"network,omitempty"`
-		Bridge  string `xml:"bridge,omitempty,attr" json:"bridge,omitempty"`
-	}
-
-	InterfaceMac struct {
-		Address string `xml:"address,attr" json:"address"`
-	}
-
-	InterfaceModel struct {
-		Type string `xml:"type,omitempty,attr" json:"type,omitempty"`
-	}
-
-	FilterRefParameter struct {
-		Name  string `xml:"name,attr" json:"name"`
-		Value string `xml:"value,attr" json:"value"`
-	}
-
-	FilterRef struct {
-		Filter     string               `xml:"filter,attr"  json:"filter"`
-		Parameters []FilterRefParameter `xml:"parameter" json:"parameters"`
-	}
-
-	Interface struct {
-		Type      string          `xml:"type,attr"  json:"type"`
-		Source    InterfaceSource `xml:"source,omitempty" json:"source,omitempty"`
-		Mac       InterfaceMac    `xml:"mac,omitempty" json:"mac,omitempty"`
-		Model     InterfaceModel  `xml:"model,omitempty" json:"model,omitempty"`
-		FilterRef FilterRef       `xml:"filterref,omitempty" json:"filterref,omitempty"`
-	}
-
-	Device struct {
-		Disks      []Disk      `xml:"disk" json:"disks"`
-		Interfaces []Interface `xml:"interface" json:"interfaces"`
-	}
-
-	OsType struct {
-		Type    string `xml:",chardata" json:"type,omitempty"`
-		Arch    string `xml:"arch,attr,omitempty" json:"arch,omitempty"`
-		Machine string `xml:"machine,attr,omitempty" json:"machine,omitempty"`
-	}
-
-	OsBoot struct {
-		Dev string `xml:"dev,attr,omitempty" json:"dev,omitempty"`
-	}
-
-	Os struct {
-		Type OsType `xml:"type,omitempty" json:"type,omitempty"`
-		Boot OsBoot `xml:"boot,omitempty" json:"boot,omitempty"`
-	}
-
+	// Domain mirrors the upstream libvirt domain XML schema (via
+	// libvirt-go-xml) so the JSON surface covers the full range of
+	// domain configuration instead of a hand-picked subset.
 	Domain struct {
 		*libvirt.VirDomain `xml:"-" json:"-"`
-		XMLName            struct{} `xml:"domain" json:"-"`
-		Type               string   `xml:"type,attr" json:"type"`
-		UUID               string   `xml:"uuid" json:"uuid"`
-		Name               string   `xml:"name" json:"name"`
-		Memory             int      `xml:"memory" json:"memory"`
-		VCPU               int      `xml:"vcpu" json:"vpcu"`
-		Devices            Device   `xml:"devices,omitempty" json:"devices"`
-		Os                 Os       `xml:"os,omitempty" json:"os"`
-		domain             *libvirt.VirDomain
-		State              string `xml:"-" json:"state"`
+		libvirtxml.Domain
+		domain    *libvirt.VirDomain
+		State     string             `xml:"-" json:"state"`
+		Addresses []InterfaceAddress `xml:"-" json:"addresses,omitempty"`
 	}
 
 	ErrorMsg struct {
@@ -117,6 +43,16 @@ type (
 		*gin.Context
 		V        *libvirt.VirConnection
 		freelist []Freer
+		// Release gives up this request's pooled-connection admission
+		// slot early. Handlers that need to hold a *libvirt.VirDomain (or
+		// similar) across a long-running wait or stream should call it
+		// before doing so, so the wait doesn't pin one of the pool's
+		// bounded in-flight slots for its full duration. It is set by
+		// withContext, domainHandler and volumeHandler, where it is safe
+		// to call more than once and the wrapper's deferred release
+		// becomes a no-op if this has already run. poolHandler and
+		// networkHandler leave it nil.
+		Release func()
 	}
 
 	HandlerFunc func(*Context) error
@@ -197,6 +133,11 @@ func domainAction(action string) gin.HandlerFunc {
 
 		case "create":
 			err = d.Create()
+			if err == nil && c.Query("wait_for_lease") != "" {
+				if attachLeaseInfo(c, d) {
+					return nil
+				}
+			}
 
 		case "reboot":
 			err = d.Reboot(0)
@@ -221,15 +162,19 @@ func domainAction(action string) gin.HandlerFunc {
 
 func domainHandler(fn func(*Context, *Domain) error) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
-		v, err := libvirt.NewVirConnection("qemu:///system")
+		v, release, err := pool.Acquire(uriForRequest(c))
 		if err != nil {
 			c.Abort(500)
+			return
 		}
-		defer v.CloseConnection()
+		var releaseOnce sync.Once
+		safeRelease := func() { releaseOnce.Do(release) }
+		defer safeRelease()
 		ctx := &Context{
 			Context:  c,
-			V:        &v,
+			V:        v,
 			freelist: make([]Freer, 0),
+			Release:  safeRelease,
 		}
 		defer func() {
 			for _, f := range ctx.freelist {
@@ -259,16 +204,20 @@ func domainHandler(fn func(*Context, *Domain) error) gin.HandlerFunc {
 
 func withContext(fn HandlerFunc) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
-		v, err := libvirt.NewVirConnection("qemu:///system")
+		v, release, err := pool.Acquire(uriForRequest(c))
 		if err != nil {
 			c.Abort(500)
+			return
 		}
-		defer v.CloseConnection()
+		var releaseOnce sync.Once
+		safeRelease := func() { releaseOnce.Do(release) }
+		defer safeRelease()
 
 		ctx := &Context{
 			Context:  c,
-			V:        &v,
+			V:        v,
 			freelist: make([]Freer, 0),
+			Release:  safeRelease,
 		}
 		defer func() {
 			for _, f := range ctx.freelist {
@@ -285,19 +234,61 @@ func (c *Context) JSONError(code int, err error) error {
 }
 
 func main() {
+	connect := flag.String("connect", "qemu:///system", "default libvirt connection URI")
+	flag.Parse()
+	connectURI = *connect
+
+	if err := startEventSubsystem(connectURI); err != nil {
+		panic(err)
+	}
+
 	r := gin.Default()
 	r.GET("/ping", func(c *gin.Context) {
 		c.String(200, "pong")
 	})
+	r.GET("/events", streamEvents)
+	r.GET("/debug/pool", poolStats)
+	r.GET("/metrics", metricsHandler)
 
 	domains := r.Group("/domains")
 	{
 		domains.GET("", withContext(listDomains))
 		domains.GET(":name", domainHandler(getDomain))
+		domains.POST("", withContext(createDomain))
+		domains.GET(":name/events", streamDomainEvents)
 
 		for _, action := range []string{"destroy", "create", "reboot", "resume", "suspend", "shutdown"} {
 			domains.POST(fmt.Sprintf(":name/%s", action), domainAction(action))
 		}
+
+		domains.POST(":name/snapshots", domainHandler(createSnapshot))
+	}
+
+	pools := r.Group("/pools")
+	{
+		pools.GET("", withContext(listPools))
+		pools.GET(":pool", poolHandler(getPool))
+		pools.POST("", withContext(createPool))
+		pools.DELETE(":pool", poolHandler(deletePool))
+		pools.POST(":pool/refresh", poolHandler(refreshPool))
+	}
+
+	volumes := r.Group("/pools/:pool/volumes")
+	{
+		volumes.POST("", poolHandler(createVolume))
+		volumes.GET(":volume", volumeHandler(getVolume))
+		volumes.DELETE(":volume", volumeHandler(deleteVolume))
+		volumes.POST(":volume/upload", volumeHandler(uploadVolume))
+	}
+
+	networks := r.Group("/networks")
+	{
+		networks.GET("", withContext(listNetworks))
+		networks.GET(":name", networkHandler(getNetwork))
+		networks.POST("", withContext(createNetwork))
+		networks.DELETE(":name", networkHandler(deleteNetwork))
+		networks.POST(":name/start", networkHandler(startNetwork))
+		networks.POST(":name/destroy", networkHandler(destroyNetwork))
 	}
 
 	// Listen and server on 0.0.0.0:8080
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/alexzorin/libvirt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	domainStateDesc = prometheus.NewDesc(
+		"libvirt_domain_state", "Current lifecycle state of the domain (libvirt VIR_DOMAIN_* constant).",
+		[]string{"uuid", "name"}, nil)
+	domainCPUTimeDesc = prometheus.NewDesc(
+		"libvirt_domain_cpu_time_seconds_total", "Cumulative CPU time consumed by the domain, in seconds.",
+		[]string{"uuid", "name"}, nil)
+	domainBalloonCurrentDesc = prometheus.NewDesc(
+		"libvirt_domain_balloon_current_bytes", "Current balloon memory size, in bytes.",
+		[]string{"uuid", "name"}, nil)
+	domainVcpuStateDesc = prometheus.NewDesc(
+		"libvirt_domain_vcpu_state", "Current state of a vCPU (libvirt VIR_VCPU_* constant).",
+		[]string{"uuid", "name", "vcpu"}, nil)
+	domainVcpuTimeDesc = prometheus.NewDesc(
+		"libvirt_domain_vcpu_time_seconds_total", "Cumulative CPU time consumed by a vCPU, in seconds.",
+		[]string{"uuid", "name", "vcpu"}, nil)
+	domainBlockRdBytesDesc = prometheus.NewDesc(
+		"libvirt_domain_block_rd_bytes_total", "Bytes read from a block device.",
+		[]string{"uuid", "name", "disk"}, nil)
+	domainBlockWrBytesDesc = prometheus.NewDesc(
+		"libvirt_domain_block_wr_bytes_total", "Bytes written to a block device.",
+		[]string{"uuid", "name", "disk"}, nil)
+	domainNetRxBytesDesc = prometheus.NewDesc(
+		"libvirt_domain_net_rx_bytes_total", "Bytes received on a network interface.",
+		[]string{"uuid", "name", "interface"}, nil)
+	domainNetTxBytesDesc = prometheus.NewDesc(
+		"libvirt_domain_net_tx_bytes_total", "Bytes transmitted on a network interface.",
+		[]string{"uuid", "name", "interface"}, nil)
+
+	hostMemoryTotalDesc = prometheus.NewDesc(
+		"libvirt_host_memory_total_bytes", "Total physical host memory, in bytes.", nil, nil)
+	hostCPUsDesc = prometheus.NewDesc(
+		"libvirt_host_cpus", "Number of active CPUs on the host.", nil, nil)
+
+	buildInfoDesc = prometheus.NewDesc(
+		"libvirt_build_info", "Build information for libvirt. Value is always 1.",
+		[]string{"client_version", "daemon_version"}, nil)
+)
+
+// libvirtCollector implements prometheus.Collector. There is no batched
+// stats call in this libvirt-go binding (no GetAllDomainStats), so each
+// scrape iterates domains and issues the handful of per-domain calls the
+// binding does have; scrape cost therefore scales with domain count.
+type libvirtCollector struct{}
+
+func (libvirtCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- domainStateDesc
+	ch <- domainCPUTimeDesc
+	ch <- domainBalloonCurrentDesc
+	ch <- domainVcpuStateDesc
+	ch <- domainVcpuTimeDesc
+	ch <- domainBlockRdBytesDesc
+	ch <- domainBlockWrBytesDesc
+	ch <- domainNetRxBytesDesc
+	ch <- domainNetTxBytesDesc
+	ch <- hostMemoryTotalDesc
+	ch <- hostCPUsDesc
+	ch <- buildInfoDesc
+}
+
+func (libvirtCollector) Collect(ch chan<- prometheus.Metric) {
+	v, release, err := pool.Acquire(connectURI)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(buildInfoDesc, err)
+		return
+	}
+	defer release()
+
+	collectHostMetrics(ch, v)
+	collectDomainMetrics(ch, v)
+}
+
+func collectHostMetrics(ch chan<- prometheus.Metric, v *libvirt.VirConnection) {
+	if node, err := v.GetNodeInfo(); err == nil {
+		ch <- prometheus.MustNewConstMetric(hostMemoryTotalDesc, prometheus.GaugeValue, float64(node.GetMemoryKB())*1024)
+		ch <- prometheus.MustNewConstMetric(hostCPUsDesc, prometheus.GaugeValue, float64(node.GetCPUs()))
+	}
+
+	// GetVersion is the libvirt release linked into this client; GetLibVersion
+	// is the libvirt release linked into the daemon serving this connection.
+	// There is no hypervisor-version call in this binding.
+	clientVer, clientErr := libvirt.GetVersion()
+	daemonVer, daemonErr := v.GetLibVersion()
+	if clientErr == nil && daemonErr == nil {
+		ch <- prometheus.MustNewConstMetric(buildInfoDesc, prometheus.GaugeValue, 1, formatVersion(clientVer), formatVersion(daemonVer))
+	}
+}
+
+func collectDomainMetrics(ch chan<- prometheus.Metric, v *libvirt.VirConnection) {
+	doms, err := v.ListAllDomains(0)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(domainStateDesc, err)
+		return
+	}
+
+	for i := range doms {
+		d, err := buildDomain(&doms[i])
+		if err != nil {
+			continue
+		}
+		collectOneDomainMetrics(ch, d)
+		d.Free()
+	}
+}
+
+func collectOneDomainMetrics(ch chan<- prometheus.Metric, d *Domain) {
+	uuid, _ := d.GetUUIDString()
+	name, _ := d.GetName()
+
+	if state, err := d.GetState(); err == nil && len(state) > 0 {
+		ch <- prometheus.MustNewConstMetric(domainStateDesc, prometheus.GaugeValue, float64(state[0]), uuid, name)
+	}
+
+	info, err := d.GetInfo()
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(domainCPUTimeDesc, prometheus.CounterValue, float64(info.GetCpuTime())/1e9, uuid, name)
+		ch <- prometheus.MustNewConstMetric(domainBalloonCurrentDesc, prometheus.GaugeValue, float64(info.GetMemory())*1024, uuid, name)
+
+		if nrVcpu := info.GetNrVirtCpu(); nrVcpu > 0 {
+			if vcpus, err := d.GetVcpus(int32(nrVcpu)); err == nil {
+				for _, vcpu := range vcpus {
+					label := fmt.Sprintf("%d", vcpu.Number)
+					ch <- prometheus.MustNewConstMetric(domainVcpuStateDesc, prometheus.GaugeValue, float64(vcpu.State), uuid, name, label)
+					ch <- prometheus.MustNewConstMetric(domainVcpuTimeDesc, prometheus.CounterValue, float64(vcpu.CpuTime)/1e9, uuid, name, label)
+				}
+			}
+		}
+	}
+
+	if d.Devices == nil {
+		return
+	}
+
+	for _, disk := range d.Devices.Disks {
+		if disk.Target == nil || disk.Target.Dev == "" {
+			continue
+		}
+		stats, err := d.BlockStats(disk.Target.Dev)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(domainBlockRdBytesDesc, prometheus.CounterValue, float64(stats.RdBytes), uuid, name, disk.Target.Dev)
+		ch <- prometheus.MustNewConstMetric(domainBlockWrBytesDesc, prometheus.CounterValue, float64(stats.WrBytes), uuid, name, disk.Target.Dev)
+	}
+
+	for _, iface := range d.Devices.Interfaces {
+		if iface.Target == nil || iface.Target.Dev == "" {
+			continue
+		}
+		stats, err := d.InterfaceStats(iface.Target.Dev)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(domainNetRxBytesDesc, prometheus.CounterValue, float64(stats.RxBytes), uuid, name, iface.Target.Dev)
+		ch <- prometheus.MustNewConstMetric(domainNetTxBytesDesc, prometheus.CounterValue, float64(stats.TxBytes), uuid, name, iface.Target.Dev)
+	}
+}
+
+// formatVersion decodes a libvirt version int (major * 1,000,000 +
+// minor * 1,000 + release) into a dotted version string.
+func formatVersion(v uint32) string {
+	major := v / 1000000
+	minor := (v % 1000000) / 1000
+	release := v % 1000
+	return fmt.Sprintf("%d.%d.%d", major, minor, release)
+}
+
+var promHandler = promhttp.Handler()
+
+func init() {
+	prometheus.MustRegister(libvirtCollector{})
+}
+
+func metricsHandler(c *gin.Context) {
+	promHandler.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"runtime"
+	"strings"
+
+	"github.com/alexzorin/libvirt-go"
+	"github.com/gin-gonic/gin"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+type (
+	// Network mirrors the upstream libvirt network XML schema (via
+	// libvirt-go-xml) the same way Domain mirrors the domain schema.
+	Network struct {
+		*libvirt.VirNetwork `xml:"-" json:"-"`
+		libvirtxml.Network
+		Active bool        `xml:"-" json:"active"`
+		Leases []DHCPLease `xml:"-" json:"leases,omitempty"`
+	}
+
+	// DHCPLease is the JSON shape of a single lease reported by
+	// GetDHCPLeases.
+	DHCPLease struct {
+		Interface  string `json:"interface"`
+		MAC        string `json:"mac"`
+		IPAddress  string `json:"ip_address"`
+		Hostname   string `json:"hostname,omitempty"`
+		ExpiryTime int64  `json:"expiry_time"`
+	}
+)
+
+func (n *Network) Free() {
+	if n.VirNetwork != nil {
+		n.VirNetwork.Free()
+		n.VirNetwork = nil
+	}
+}
+
+func buildNetwork(net *libvirt.VirNetwork) (*Network, error) {
+	n := new(Network)
+	n.VirNetwork = net
+
+	xmldesc, err := n.GetXMLDesc(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal([]byte(xmldesc), n); err != nil {
+		return nil, err
+	}
+
+	active, err := n.IsActive()
+	if err != nil {
+		return nil, err
+	}
+	n.Active = active
+
+	if active {
+		leases, err := n.GetDHCPLeases()
+		if err != nil {
+			return nil, err
+		}
+		n.Leases = make([]DHCPLease, len(leases))
+		for i, l := range leases {
+			n.Leases[i] = DHCPLease{
+				Interface:  l.GetIface(),
+				MAC:        l.GetMACAddress(),
+				IPAddress:  l.GetIPAddress(),
+				Hostname:   l.GetHostname(),
+				ExpiryTime: l.GetExpiryTime().Unix(),
+			}
+		}
+	}
+
+	runtime.SetFinalizer(n, func(n *Network) {
+		n.Free()
+	})
+	return n, nil
+}
+
+func listNetworks(c *Context) error {
+	nets, err := c.V.ListAllNetworks(0)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+	result := make([]*Network, len(nets))
+	for i := range nets {
+		n, err := buildNetwork(&nets[i])
+		if err != nil {
+			return c.JSONError(500, err)
+		}
+		c.FreeList(n)
+		result[i] = n
+	}
+	c.JSON(200, result)
+	return nil
+}
+
+func getNetwork(c *Context, n *Network) error {
+	c.JSON(200, n)
+	return nil
+}
+
+// createNetwork handles POST /networks. The request body is the JSON
+// form of a libvirtxml.Network, which is marshaled to XML and handed to
+// NetworkDefineXML. Pass ?start=true to create (start) the network
+// immediately after it is defined.
+func createNetwork(c *Context) error {
+	var spec libvirtxml.Network
+	dec := json.NewDecoder(c.Request.Body)
+	if err := dec.Decode(&spec); err != nil {
+		return c.JSONError(400, err)
+	}
+
+	xmlDoc, err := xml.Marshal(&spec)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+
+	net, err := c.V.NetworkDefineXML(string(xmlDoc))
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+
+	if c.Query("start") == "true" {
+		if err := net.Create(); err != nil {
+			return c.JSONError(500, err)
+		}
+	}
+
+	n, err := buildNetwork(&net)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+	c.FreeList(n)
+	c.JSON(201, n)
+	return nil
+}
+
+func deleteNetwork(c *Context, n *Network) error {
+	// Best-effort stop if the network is active; Undefine below is what
+	// actually matters and is reported on failure.
+	n.Destroy()
+
+	if err := n.Undefine(); err != nil {
+		return c.JSONError(500, err)
+	}
+	c.JSON(200, StatusMsg{Status: "deleted"})
+	return nil
+}
+
+func startNetwork(c *Context, n *Network) error {
+	if err := n.Create(); err != nil {
+		return c.JSONError(500, err)
+	}
+	c.JSON(200, n)
+	return nil
+}
+
+func destroyNetwork(c *Context, n *Network) error {
+	if err := n.Destroy(); err != nil {
+		return c.JSONError(500, err)
+	}
+	c.JSON(200, n)
+	return nil
+}
+
+// Adding or removing a static DHCP host reservation at runtime
+// (virNetworkUpdate, the C API this would need) is intentionally not
+// implemented: VirNetwork in this libvirt-go binding has no Update
+// method and none of the VIR_NETWORK_UPDATE_*/VIR_NETWORK_SECTION_*
+// constants it would take are defined here either. A host reservation
+// can still be added by redefining the whole network (NetworkDefineXML
+// with the <dhcp> section included), which createNetwork already
+// supports.
+
+// networkHandler acquires a pooled connection, looks up the network
+// named by the :name URL parameter, and invokes fn with it.
+func networkHandler(fn func(*Context, *Network) error) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		v, release, err := pool.Acquire(uriForRequest(c))
+		if err != nil {
+			c.Abort(500)
+			return
+		}
+		defer release()
+		ctx := &Context{
+			Context:  c,
+			V:        v,
+			freelist: make([]Freer, 0),
+		}
+		defer func() {
+			for _, f := range ctx.freelist {
+				f.Free()
+			}
+		}()
+
+		name := c.Params.ByName("name")
+		net, err := v.LookupNetworkByName(name)
+		if err != nil {
+			code := 500
+			if strings.Contains(err.Error(), "Network not found") {
+				code = 404
+			}
+			ctx.JSONError(code, err)
+			return
+		}
+		n, err := buildNetwork(&net)
+		if err != nil {
+			ctx.JSONError(500, err)
+			return
+		}
+		defer n.Free()
+		fn(ctx, n)
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/alexzorin/libvirt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// maxInFlightPerConn bounds the number of concurrent requests allowed to
+// use a single pooled connection at once. libvirt-go connections are not
+// safe for unbounded concurrent use across all APIs, so this acts as a
+// simple admission control rather than a true connection multiplexer.
+const maxInFlightPerConn = 16
+
+// connEntry is a single pooled connection to a libvirt URI, along with a
+// semaphore limiting how many requests may use it concurrently.
+type connEntry struct {
+	uri  string
+	mu   sync.Mutex
+	conn libvirt.VirConnection
+	open bool
+	sem  chan struct{}
+}
+
+// get returns a live connection for this entry, reconnecting if the
+// existing one has gone away.
+func (e *connEntry) get() (*libvirt.VirConnection, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.open {
+		if alive, err := e.conn.IsAlive(); err == nil && alive {
+			return &e.conn, nil
+		}
+		e.conn.CloseConnection()
+		e.open = false
+	}
+
+	conn, err := libvirt.NewVirConnection(e.uri)
+	if err != nil {
+		return nil, err
+	}
+	e.conn = conn
+	e.open = true
+	return &e.conn, nil
+}
+
+func (e *connEntry) acquire() { e.sem <- struct{}{} }
+func (e *connEntry) release() { <-e.sem }
+
+// ConnPool is a bounded pool of persistent libvirt connections keyed by
+// connection URI. It replaces opening and closing a new connection on
+// every HTTP request.
+type ConnPool struct {
+	mu    sync.Mutex
+	conns map[string]*connEntry
+}
+
+func NewConnPool() *ConnPool {
+	return &ConnPool{conns: make(map[string]*connEntry)}
+}
+
+func (p *ConnPool) entry(uri string) *connEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.conns[uri]
+	if !ok {
+		e = &connEntry{uri: uri, sem: make(chan struct{}, maxInFlightPerConn)}
+		p.conns[uri] = e
+	}
+	return e
+}
+
+// Acquire returns a healthy connection for uri and a release func that
+// must be called once the caller is done with it.
+func (p *ConnPool) Acquire(uri string) (*libvirt.VirConnection, func(), error) {
+	e := p.entry(uri)
+	e.acquire()
+
+	conn, err := e.get()
+	if err != nil {
+		e.release()
+		return nil, nil, err
+	}
+	return conn, e.release, nil
+}
+
+// PoolConnStats is the JSON shape of a single pooled connection's status,
+// served at /debug/pool.
+type PoolConnStats struct {
+	URI         string `json:"uri"`
+	Open        bool   `json:"open"`
+	InFlight    int    `json:"in_flight"`
+	MaxInFlight int    `json:"max_in_flight"`
+}
+
+func (p *ConnPool) Stats() []PoolConnStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]PoolConnStats, 0, len(p.conns))
+	for _, e := range p.conns {
+		e.mu.Lock()
+		stats = append(stats, PoolConnStats{
+			URI:         e.uri,
+			Open:        e.open,
+			InFlight:    len(e.sem),
+			MaxInFlight: cap(e.sem),
+		})
+		e.mu.Unlock()
+	}
+	return stats
+}
+
+var pool = NewConnPool()
+
+// connectURI is the default libvirt URI used when a request does not
+// supply an X-Libvirt-URI header. Set from the --connect flag in main().
+var connectURI = "qemu:///system"
+
+// uriForRequest resolves which libvirt URI a request should use: the
+// X-Libvirt-URI header if present, otherwise the process-wide default.
+func uriForRequest(c *gin.Context) string {
+	if uri := c.Request.Header.Get("X-Libvirt-URI"); uri != "" {
+		return uri
+	}
+	return connectURI
+}
+
+func poolStats(c *gin.Context) {
+	c.JSON(200, pool.Stats())
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// Snapshot is the JSON response for a created domain snapshot. It
+// mirrors the request spec rather than a live libvirt handle: see the
+// comment on createSnapshot for why.
+type Snapshot struct {
+	libvirtxml.DomainSnapshot
+}
+
+// createSnapshot handles POST /domains/:name/snapshots. The request body
+// is the JSON form of a libvirtxml.DomainSnapshot, which is marshaled to
+// XML and handed to CreateSnapshotXML.
+//
+// Listing snapshots, looking one up by name, reverting, deleting,
+// migrating a domain, and polling block-job progress are not
+// implemented here: this libvirt-go binding has no
+// ListAllSnapshots/SnapshotLookupByName/MigrateToURI3/GetBlockJobInfo
+// equivalent (nor any migration or block-job-info API at all), and
+// VirDomainSnapshot itself exposes no XML getter, so the handle
+// CreateSnapshotXML returns can't be re-derived or looked up again
+// once this request returns. Surfacing those operations would need
+// either an in-process snapshot registry (fragile across restarts and
+// multi-instance deployments) or a different libvirt binding; neither
+// is in scope here.
+func createSnapshot(c *Context, d *Domain) error {
+	var spec libvirtxml.DomainSnapshot
+	dec := json.NewDecoder(c.Request.Body)
+	if err := dec.Decode(&spec); err != nil {
+		return c.JSONError(400, err)
+	}
+
+	xmlDoc, err := xml.Marshal(&spec)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+
+	snap, err := d.CreateSnapshotXML(string(xmlDoc), 0)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+	defer snap.Free()
+
+	c.JSON(201, Snapshot{DomainSnapshot: spec})
+	return nil
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/alexzorin/libvirt-go"
+	"github.com/gin-gonic/gin"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+const uploadChunkSize = 256 * 1024
+
+var poolStateDict = map[uint8]string{
+	libvirt.VIR_STORAGE_POOL_INACTIVE:     "inactive",
+	libvirt.VIR_STORAGE_POOL_BUILDING:     "building",
+	libvirt.VIR_STORAGE_POOL_RUNNING:      "running",
+	libvirt.VIR_STORAGE_POOL_DEGRADED:     "degraded",
+	libvirt.VIR_STORAGE_POOL_INACCESSIBLE: "inaccessible",
+}
+
+type (
+	// Pool mirrors the upstream libvirt storage pool XML schema (via
+	// libvirt-go-xml) the same way Domain mirrors the domain schema.
+	Pool struct {
+		*libvirt.VirStoragePool `xml:"-" json:"-"`
+		libvirtxml.StoragePool
+		State string `xml:"-" json:"state"`
+	}
+
+	// Volume mirrors the upstream libvirt storage volume XML schema.
+	Volume struct {
+		*libvirt.VirStorageVol `xml:"-" json:"-"`
+		libvirtxml.StorageVolume
+	}
+
+	// volumeCreateRequest is the JSON body accepted by POST
+	// /pools/:pool/volumes. BackingStore names an existing volume in the
+	// same pool to clone from (StorageVolCreateXMLFrom) instead of
+	// creating an empty volume; it is distinct from libvirtxml's own
+	// BackingStore field, which describes a qcow2 backing *file* rather
+	// than a volume to clone.
+	volumeCreateRequest struct {
+		libvirtxml.StorageVolume
+		BackingStore string `json:"backing_store,omitempty"`
+	}
+
+	// StatusMsg is a minimal JSON ack for operations that don't return a
+	// resource, such as delete and upload.
+	StatusMsg struct {
+		Status string `json:"status"`
+	}
+)
+
+func (p *Pool) Free() {
+	if p.VirStoragePool != nil {
+		p.VirStoragePool.Free()
+		p.VirStoragePool = nil
+	}
+}
+
+func (v *Volume) Free() {
+	if v.VirStorageVol != nil {
+		v.VirStorageVol.Free()
+		v.VirStorageVol = nil
+	}
+}
+
+func buildPool(sp *libvirt.VirStoragePool) (*Pool, error) {
+	p := new(Pool)
+	p.VirStoragePool = sp
+
+	xmldesc, err := p.GetXMLDesc(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal([]byte(xmldesc), p); err != nil {
+		return nil, err
+	}
+
+	info, err := p.GetInfo()
+	if err != nil {
+		return nil, err
+	}
+	p.State = poolStateDict[uint8(info.State)]
+
+	runtime.SetFinalizer(p, func(p *Pool) {
+		p.Free()
+	})
+	return p, nil
+}
+
+func buildVolume(vol *libvirt.VirStorageVol) (*Volume, error) {
+	v := new(Volume)
+	v.VirStorageVol = vol
+
+	xmldesc, err := v.GetXMLDesc(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal([]byte(xmldesc), v); err != nil {
+		return nil, err
+	}
+
+	runtime.SetFinalizer(v, func(v *Volume) {
+		v.Free()
+	})
+	return v, nil
+}
+
+func listPools(c *Context) error {
+	pools, err := c.V.ListAllStoragePools(0)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+	result := make([]*Pool, len(pools))
+	for i := range pools {
+		p, err := buildPool(&pools[i])
+		if err != nil {
+			return c.JSONError(500, err)
+		}
+		c.FreeList(p)
+		result[i] = p
+	}
+	c.JSON(200, result)
+	return nil
+}
+
+func getPool(c *Context, p *Pool) error {
+	c.JSON(200, p)
+	return nil
+}
+
+// createPool handles POST /pools. The request body is the JSON form of a
+// libvirtxml.StoragePool, which is marshaled to XML and handed to
+// StoragePoolDefineXML. Pass ?start=true to create (start) the pool
+// immediately after it is defined.
+func createPool(c *Context) error {
+	var spec libvirtxml.StoragePool
+	dec := json.NewDecoder(c.Request.Body)
+	if err := dec.Decode(&spec); err != nil {
+		return c.JSONError(400, err)
+	}
+
+	xmlDoc, err := xml.Marshal(&spec)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+
+	sp, err := c.V.StoragePoolDefineXML(string(xmlDoc), 0)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+
+	if c.Query("start") == "true" {
+		if err := sp.Create(0); err != nil {
+			return c.JSONError(500, err)
+		}
+	}
+
+	p, err := buildPool(&sp)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+	c.FreeList(p)
+	c.JSON(201, p)
+	return nil
+}
+
+func deletePool(c *Context, p *Pool) error {
+	// Best-effort stop if the pool is active; Undefine below is what
+	// actually matters and is reported on failure.
+	p.Destroy()
+
+	if err := p.Undefine(); err != nil {
+		return c.JSONError(500, err)
+	}
+	c.JSON(200, StatusMsg{Status: "deleted"})
+	return nil
+}
+
+func refreshPool(c *Context, p *Pool) error {
+	if err := p.Refresh(0); err != nil {
+		return c.JSONError(500, err)
+	}
+	c.JSON(200, p)
+	return nil
+}
+
+// Listing the volumes in a pool is intentionally not implemented: this
+// libvirt-go binding has no ListAllStorageVolumes (or any other
+// enumeration call) on VirStoragePool, only LookupStorageVolByName.
+// Callers that need to discover a volume's name have to get it from
+// wherever the volume was created (e.g. the response of createVolume).
+
+func getVolume(c *Context, p *Pool, v *Volume) error {
+	c.JSON(200, v)
+	return nil
+}
+
+// createVolume handles POST /pools/:pool/volumes. The request body is the
+// JSON form of a libvirtxml.StorageVolume plus an optional backing_store
+// field naming an existing volume in the same pool to clone from
+// (StorageVolCreateXMLFrom) instead of allocating an empty volume
+// (StorageVolCreateXML).
+func createVolume(c *Context, p *Pool) error {
+	var req volumeCreateRequest
+	dec := json.NewDecoder(c.Request.Body)
+	if err := dec.Decode(&req); err != nil {
+		return c.JSONError(400, err)
+	}
+
+	xmlDoc, err := xml.Marshal(&req.StorageVolume)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+
+	var vol libvirt.VirStorageVol
+	if req.BackingStore != "" {
+		base, baseErr := p.LookupStorageVolByName(req.BackingStore)
+		if baseErr != nil {
+			return c.JSONError(404, fmt.Errorf("backing volume %q not found: %s", req.BackingStore, baseErr))
+		}
+		defer base.Free()
+		vol, err = p.StorageVolCreateXMLFrom(string(xmlDoc), base, 0)
+	} else {
+		vol, err = p.StorageVolCreateXML(string(xmlDoc), 0)
+	}
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+
+	v, err := buildVolume(&vol)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+	c.FreeList(v)
+	c.JSON(201, v)
+	return nil
+}
+
+func deleteVolume(c *Context, p *Pool, v *Volume) error {
+	if err := v.Delete(0); err != nil {
+		return c.JSONError(500, err)
+	}
+	c.JSON(200, StatusMsg{Status: "deleted"})
+	return nil
+}
+
+// uploadVolume handles POST /pools/:pool/volumes/:volume/upload, streaming
+// the raw request body into the volume via a libvirt stream.
+func uploadVolume(c *Context, p *Pool, v *Volume) error {
+	stream, err := libvirt.NewVirStream(c.V, 0)
+	if err != nil {
+		return c.JSONError(500, err)
+	}
+	defer stream.Free()
+
+	if err := v.Upload(stream, 0, 0, 0); err != nil {
+		return c.JSONError(500, err)
+	}
+
+	// The copy loop below can run for as long as the client takes to
+	// send its body (minutes, for a multi-GB image over a slow link).
+	// Give up our pooled connection's admission slot now rather than
+	// pinning it for that whole duration: the stream and volume handles
+	// already obtained stay valid independent of the pool's bookkeeping.
+	c.Release()
+
+	buf := make([]byte, uploadChunkSize)
+	for {
+		n, rerr := c.Request.Body.Read(buf)
+		if n > 0 {
+			if _, werr := stream.Write(buf[:n]); werr != nil {
+				stream.Abort()
+				return c.JSONError(500, werr)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			stream.Abort()
+			return c.JSONError(500, rerr)
+		}
+	}
+
+	// Close finishes the upload stream (virStreamFinish), committing the
+	// data written above; it is not a handle-release call like Free.
+	if err := stream.Close(); err != nil {
+		return c.JSONError(500, err)
+	}
+	c.JSON(200, StatusMsg{Status: "uploaded"})
+	return nil
+}
+
+// poolHandler acquires a pooled connection, looks up the pool named by
+// the :pool URL parameter, and invokes fn with it.
+func poolHandler(fn func(*Context, *Pool) error) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		v, release, err := pool.Acquire(uriForRequest(c))
+		if err != nil {
+			c.Abort(500)
+			return
+		}
+		defer release()
+		ctx := &Context{
+			Context:  c,
+			V:        v,
+			freelist: make([]Freer, 0),
+		}
+		defer func() {
+			for _, f := range ctx.freelist {
+				f.Free()
+			}
+		}()
+
+		name := c.Params.ByName("pool")
+		sp, err := v.LookupStoragePoolByName(name)
+		if err != nil {
+			code := 500
+			if strings.Contains(err.Error(), "Storage pool not found") {
+				code = 404
+			}
+			ctx.JSONError(code, err)
+			return
+		}
+		p, err := buildPool(&sp)
+		if err != nil {
+			ctx.JSONError(500, err)
+			return
+		}
+		defer p.Free()
+		fn(ctx, p)
+	})
+}
+
+// volumeHandler acquires a pooled connection, looks up the pool and
+// volume named by the :pool and :volume URL parameters, and invokes fn
+// with both.
+func volumeHandler(fn func(*Context, *Pool, *Volume) error) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		v, release, err := pool.Acquire(uriForRequest(c))
+		if err != nil {
+			c.Abort(500)
+			return
+		}
+		var releaseOnce sync.Once
+		safeRelease := func() { releaseOnce.Do(release) }
+		defer safeRelease()
+		ctx := &Context{
+			Context:  c,
+			V:        v,
+			freelist: make([]Freer, 0),
+			Release:  safeRelease,
+		}
+		defer func() {
+			for _, f := range ctx.freelist {
+				f.Free()
+			}
+		}()
+
+		poolName := c.Params.ByName("pool")
+		sp, err := v.LookupStoragePoolByName(poolName)
+		if err != nil {
+			code := 500
+			if strings.Contains(err.Error(), "Storage pool not found") {
+				code = 404
+			}
+			ctx.JSONError(code, err)
+			return
+		}
+		p, err := buildPool(&sp)
+		if err != nil {
+			ctx.JSONError(500, err)
+			return
+		}
+		defer p.Free()
+
+		volName := c.Params.ByName("volume")
+		vol, err := sp.LookupStorageVolByName(volName)
+		if err != nil {
+			code := 500
+			if strings.Contains(err.Error(), "Storage volume not found") {
+				code = 404
+			}
+			ctx.JSONError(code, err)
+			return
+		}
+		vv, err := buildVolume(&vol)
+		if err != nil {
+			ctx.JSONError(500, err)
+			return
+		}
+		defer vv.Free()
+		fn(ctx, p, vv)
+	})
+}